@@ -0,0 +1,177 @@
+package router
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// BackendSelector picks one backend id out of a set of candidates that are
+// all known to serve the same route. Implementations must be safe for
+// concurrent use.
+type BackendSelector interface {
+	Select(candidates []string) string
+}
+
+// RandomSelector is the historical behavior: a uniform random pick.
+type RandomSelector struct{}
+
+func NewRandomSelector() *RandomSelector {
+	return &RandomSelector{}
+}
+
+func (s *RandomSelector) Select(candidates []string) string {
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// RoundRobinSelector cycles through candidates in order. The cursor is
+// shared across all candidate sets seen by this selector, which is good
+// enough to avoid herding without the cost of per-route state.
+type RoundRobinSelector struct {
+	mutex  sync.Mutex
+	cursor uint64
+}
+
+func NewRoundRobinSelector() *RoundRobinSelector {
+	return &RoundRobinSelector{}
+}
+
+func (s *RoundRobinSelector) Select(candidates []string) string {
+	s.mutex.Lock()
+	i := s.cursor
+	s.cursor++
+	s.mutex.Unlock()
+
+	return candidates[i%uint64(len(candidates))]
+}
+
+// LeastConnSelector picks the candidate with the fewest in-flight requests,
+// as tracked by BackendStats.
+type LeastConnSelector struct {
+	stats *BackendStats
+}
+
+func NewLeastConnSelector(stats *BackendStats) *LeastConnSelector {
+	return &LeastConnSelector{stats: stats}
+}
+
+func (s *LeastConnSelector) Select(candidates []string) string {
+	best := candidates[0]
+	bestConns := s.stats.ActiveConns(best)
+
+	for _, id := range candidates[1:] {
+		if conns := s.stats.ActiveConns(id); conns < bestConns {
+			best = id
+			bestConns = conns
+		}
+	}
+
+	return best
+}
+
+// EWMALatencySelector picks the candidate with the lowest exponentially
+// weighted moving average response latency, as tracked by BackendStats.
+type EWMALatencySelector struct {
+	stats *BackendStats
+}
+
+func NewEWMALatencySelector(stats *BackendStats) *EWMALatencySelector {
+	return &EWMALatencySelector{stats: stats}
+}
+
+func (s *EWMALatencySelector) Select(candidates []string) string {
+	best := candidates[0]
+	bestLatency := s.stats.EWMALatency(best)
+
+	for _, id := range candidates[1:] {
+		if latency := s.stats.EWMALatency(id); latency < bestLatency {
+			best = id
+			bestLatency = latency
+		}
+	}
+
+	return best
+}
+
+// PowerOfTwoChoicesSelector samples two candidates at random and picks the
+// one with fewer active connections. This gives load distribution close to
+// LeastConnSelector in O(1) instead of scanning every candidate, which
+// matters once the candidate set is large.
+type PowerOfTwoChoicesSelector struct {
+	stats *BackendStats
+}
+
+func NewPowerOfTwoChoicesSelector(stats *BackendStats) *PowerOfTwoChoicesSelector {
+	return &PowerOfTwoChoicesSelector{stats: stats}
+}
+
+func (s *PowerOfTwoChoicesSelector) Select(candidates []string) string {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+
+	a, b := candidates[i], candidates[j]
+	if s.stats.ActiveConns(a) <= s.stats.ActiveConns(b) {
+		return a
+	}
+	return b
+}
+
+// BackendStats tracks the per-backend counters that health-aware selectors
+// read from. It is updated from the CaptureBackendRequest/
+// CaptureBackendResponse paths in Proxy.ServeHTTP.
+type BackendStats struct {
+	mutex sync.Mutex
+	conns map[string]int64
+	ewma  map[string]float64
+}
+
+// ewmaAlpha controls how quickly EWMALatency reacts to new samples.
+const ewmaAlpha = 0.2
+
+func NewBackendStats() *BackendStats {
+	return &BackendStats{
+		conns: make(map[string]int64),
+		ewma:  make(map[string]float64),
+	}
+}
+
+func (s *BackendStats) StartRequest(id string) {
+	s.mutex.Lock()
+	s.conns[id]++
+	s.mutex.Unlock()
+}
+
+func (s *BackendStats) EndRequest(id string, latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conns[id] > 0 {
+		s.conns[id]--
+	}
+
+	sample := float64(latency)
+	if cur, ok := s.ewma[id]; ok {
+		s.ewma[id] = ewmaAlpha*sample + (1-ewmaAlpha)*cur
+	} else {
+		s.ewma[id] = sample
+	}
+}
+
+func (s *BackendStats) ActiveConns(id string) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.conns[id]
+}
+
+func (s *BackendStats) EWMALatency(id string) float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.ewma[id]
+}
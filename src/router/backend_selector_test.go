@@ -0,0 +1,96 @@
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRandomSelectorReturnsACandidate(t *testing.T) {
+	s := NewRandomSelector()
+	candidates := []string{"a", "b", "c"}
+
+	for i := 0; i < 20; i++ {
+		got := s.Select(candidates)
+		found := false
+		for _, c := range candidates {
+			if c == got {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("Select returned %q, not one of %v", got, candidates)
+		}
+	}
+}
+
+func TestRoundRobinSelectorCyclesThroughCandidates(t *testing.T) {
+	s := NewRoundRobinSelector()
+	candidates := []string{"a", "b", "c"}
+
+	want := []string{"a", "b", "c", "a", "b"}
+	for i, w := range want {
+		if got := s.Select(candidates); got != w {
+			t.Fatalf("call %d: got %q, want %q", i, got, w)
+		}
+	}
+}
+
+func TestLeastConnSelectorPrefersFewestActiveConns(t *testing.T) {
+	stats := NewBackendStats()
+	stats.StartRequest("busy")
+	stats.StartRequest("busy")
+	stats.StartRequest("idle")
+	stats.EndRequest("idle", 0)
+
+	s := NewLeastConnSelector(stats)
+	if got := s.Select([]string{"busy", "idle"}); got != "idle" {
+		t.Fatalf("got %q, want %q", got, "idle")
+	}
+}
+
+func TestEWMALatencySelectorPrefersLowerLatency(t *testing.T) {
+	stats := NewBackendStats()
+	stats.StartRequest("slow")
+	stats.EndRequest("slow", 100*time.Millisecond)
+	stats.StartRequest("fast")
+	stats.EndRequest("fast", 1*time.Millisecond)
+
+	s := NewEWMALatencySelector(stats)
+	if got := s.Select([]string{"slow", "fast"}); got != "fast" {
+		t.Fatalf("got %q, want %q", got, "fast")
+	}
+}
+
+func TestPowerOfTwoChoicesSelectorPicksLessLoaded(t *testing.T) {
+	stats := NewBackendStats()
+	stats.StartRequest("busy")
+	stats.StartRequest("busy")
+	stats.StartRequest("busy")
+
+	s := NewPowerOfTwoChoicesSelector(stats)
+	candidates := []string{"busy", "idle"}
+
+	// With only two candidates, every sample pair is {busy, idle}, so the
+	// selector must always pick idle.
+	for i := 0; i < 20; i++ {
+		if got := s.Select(candidates); got != "idle" {
+			t.Fatalf("iteration %d: got %q, want %q", i, got, "idle")
+		}
+	}
+}
+
+func TestPowerOfTwoChoicesSelectorSingleCandidate(t *testing.T) {
+	s := NewPowerOfTwoChoicesSelector(NewBackendStats())
+	if got := s.Select([]string{"only"}); got != "only" {
+		t.Fatalf("got %q, want %q", got, "only")
+	}
+}
+
+func TestBackendStatsEndRequestWithoutStartIsNotNegative(t *testing.T) {
+	stats := NewBackendStats()
+	stats.EndRequest("never-started", time.Millisecond)
+
+	if got := stats.ActiveConns("never-started"); got != 0 {
+		t.Fatalf("ActiveConns = %d, want 0", got)
+	}
+}
@@ -0,0 +1,110 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// hopHeaders are stripped from both the request sent upstream and the
+// response sent back to the client, per RFC 7230 section 6.1. Transfer-
+// Encoding is deliberately included: we always re-frame the body ourselves.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailers",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHopHeaders deletes the standard hop-by-hop headers as well as
+// any additional header named as a token in the Connection header, mirroring
+// net/http/httputil.ReverseProxy.
+func removeHopByHopHeaders(header http.Header) {
+	if c := header.Get("Connection"); c != "" {
+		for _, token := range strings.Split(c, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				header.Del(token)
+			}
+		}
+	}
+
+	for _, h := range hopHeaders {
+		header.Del(h)
+	}
+}
+
+// HeaderRewriter lets callers add or remove request headers before a
+// request is forwarded to a backend.
+type HeaderRewriter interface {
+	Rewrite(header http.Header)
+}
+
+// setForwardedHeaders sets X-Forwarded-Proto, X-Forwarded-Host and the
+// RFC 7239 Forwarded header, and appends to (or sets) X-Forwarded-For.
+// Client-supplied X-Forwarded-For is only trusted, and so preserved, when
+// the immediate peer (remoteAddr) matches one of trustedProxies; otherwise
+// it is replaced with just the peer's address.
+func setForwardedHeaders(header http.Header, remoteAddr string, scheme string, host string, trustedProxies []*net.IPNet) {
+	peer, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		peer = remoteAddr
+	}
+
+	if !isTrustedProxy(peer, trustedProxies) {
+		header.Del("X-Forwarded-For")
+		header.Del("Forwarded")
+	}
+
+	xff := header.Get("X-Forwarded-For")
+	if xff == "" {
+		xff = peer
+	} else {
+		xff = xff + ", " + peer
+	}
+	header.Set("X-Forwarded-For", xff)
+
+	header.Set("X-Forwarded-Proto", scheme)
+	header.Set("X-Forwarded-Host", host)
+
+	element := "for=" + peer + "; proto=" + scheme + "; host=" + host
+	if existing := header.Get("Forwarded"); existing != "" {
+		header.Set("Forwarded", existing+", "+element)
+	} else {
+		header.Set("Forwarded", element)
+	}
+}
+
+func isTrustedProxy(peer string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(peer)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseTrustedProxies parses a list of CIDRs from config, silently skipping
+// any entry that doesn't parse so a typo in config can't take the router down.
+func parseTrustedProxies(cidrs []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		nets = append(nets, n)
+	}
+
+	return nets
+}
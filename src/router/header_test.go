@@ -0,0 +1,91 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func trustedCIDR(t *testing.T, cidr string) []*net.IPNet {
+	t.Helper()
+	return parseTrustedProxies([]string{cidr})
+}
+
+func TestSetForwardedHeadersFromUntrustedPeer(t *testing.T) {
+	header := make(http.Header)
+	header.Set("X-Forwarded-For", "1.2.3.4")
+	header.Set("Forwarded", "for=1.2.3.4")
+
+	setForwardedHeaders(header, "9.9.9.9:1234", "https", "example.com", nil)
+
+	if got := header.Get("X-Forwarded-For"); got != "9.9.9.9" {
+		t.Fatalf("X-Forwarded-For = %q, want client-supplied value discarded", got)
+	}
+	if got := header.Get("Forwarded"); got != "for=9.9.9.9; proto=https; host=example.com" {
+		t.Fatalf("Forwarded = %q, want client-supplied value discarded", got)
+	}
+}
+
+func TestSetForwardedHeadersFromTrustedPeerAppends(t *testing.T) {
+	trusted := trustedCIDR(t, "10.0.0.0/8")
+
+	header := make(http.Header)
+	header.Set("X-Forwarded-For", "1.2.3.4")
+	header.Set("Forwarded", "for=1.2.3.4; proto=http; host=upstream.internal")
+
+	setForwardedHeaders(header, "10.0.0.5:1234", "https", "example.com", trusted)
+
+	if got, want := header.Get("X-Forwarded-For"), "1.2.3.4, 10.0.0.5"; got != want {
+		t.Fatalf("X-Forwarded-For = %q, want %q", got, want)
+	}
+
+	want := "for=1.2.3.4; proto=http; host=upstream.internal, for=10.0.0.5; proto=https; host=example.com"
+	if got := header.Get("Forwarded"); got != want {
+		t.Fatalf("Forwarded = %q, want %q", got, want)
+	}
+}
+
+func TestSetForwardedHeadersFirstHop(t *testing.T) {
+	header := make(http.Header)
+
+	setForwardedHeaders(header, "203.0.113.9:4321", "http", "example.com", nil)
+
+	if got, want := header.Get("X-Forwarded-For"), "203.0.113.9"; got != want {
+		t.Fatalf("X-Forwarded-For = %q, want %q", got, want)
+	}
+	if got, want := header.Get("Forwarded"), "for=203.0.113.9; proto=http; host=example.com"; got != want {
+		t.Fatalf("Forwarded = %q, want %q", got, want)
+	}
+	if got, want := header.Get("X-Forwarded-Proto"), "http"; got != want {
+		t.Fatalf("X-Forwarded-Proto = %q, want %q", got, want)
+	}
+	if got, want := header.Get("X-Forwarded-Host"), "example.com"; got != want {
+		t.Fatalf("X-Forwarded-Host = %q, want %q", got, want)
+	}
+}
+
+func TestRemoveHopByHopHeadersStripsStandardAndConnectionTokens(t *testing.T) {
+	header := make(http.Header)
+	header.Set("Connection", "Keep-Alive, X-Custom-Hop")
+	header.Set("Keep-Alive", "timeout=5")
+	header.Set("X-Custom-Hop", "should-be-removed")
+	header.Set("Content-Type", "text/plain")
+
+	removeHopByHopHeaders(header)
+
+	for _, h := range []string{"Connection", "Keep-Alive", "X-Custom-Hop"} {
+		if header.Get(h) != "" {
+			t.Fatalf("%s was not removed", h)
+		}
+	}
+	if got := header.Get("Content-Type"); got != "text/plain" {
+		t.Fatalf("Content-Type = %q, want it left alone", got)
+	}
+}
+
+func TestParseTrustedProxiesSkipsInvalidEntries(t *testing.T) {
+	nets := parseTrustedProxies([]string{"10.0.0.0/8", "not-a-cidr", "192.168.1.0/24"})
+	if len(nets) != 2 {
+		t.Fatalf("got %d nets, want 2 (invalid entry should be skipped)", len(nets))
+	}
+}
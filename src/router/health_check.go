@@ -0,0 +1,102 @@
+package router
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// HealthTracker implements passive health checking: it trips a circuit for
+// a backend after a run of consecutive failures (5xx responses or dial/
+// timeout errors) and re-admits the backend once a background probe
+// succeeds against it.
+type HealthTracker struct {
+	mutex sync.Mutex
+	state map[string]*backendHealth
+
+	// FailureThreshold is the number of consecutive failures that trips a
+	// backend's circuit.
+	FailureThreshold int
+
+	// ProbeInterval is how often a tripped backend is probed for recovery.
+	ProbeInterval time.Duration
+}
+
+type backendHealth struct {
+	consecutiveFailures int
+	tripped             bool
+}
+
+func NewHealthTracker(failureThreshold int, probeInterval time.Duration) *HealthTracker {
+	return &HealthTracker{
+		state:            make(map[string]*backendHealth),
+		FailureThreshold: failureThreshold,
+		ProbeInterval:    probeInterval,
+	}
+}
+
+// IsHealthy reports whether id is currently admitted for selection.
+func (h *HealthTracker) IsHealthy(id string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	bh, ok := h.state[id]
+	if !ok {
+		return true
+	}
+
+	return !bh.tripped
+}
+
+// MarkSuccess resets the failure count for id and clears a tripped circuit.
+func (h *HealthTracker) MarkSuccess(id string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	bh, ok := h.state[id]
+	if !ok {
+		return
+	}
+
+	bh.consecutiveFailures = 0
+	bh.tripped = false
+}
+
+// MarkFailure records a failure for id, tripping its circuit once
+// FailureThreshold consecutive failures have been seen, and starts a
+// background probe loop to re-admit it once addr is reachable again.
+func (h *HealthTracker) MarkFailure(id string, addr string) {
+	h.mutex.Lock()
+	bh, ok := h.state[id]
+	if !ok {
+		bh = &backendHealth{}
+		h.state[id] = bh
+	}
+
+	bh.consecutiveFailures++
+	tripping := !bh.tripped && bh.consecutiveFailures >= h.FailureThreshold
+	if tripping {
+		bh.tripped = true
+	}
+	h.mutex.Unlock()
+
+	if tripping {
+		go h.probeUntilHealthy(id, addr)
+	}
+}
+
+func (h *HealthTracker) probeUntilHealthy(id string, addr string) {
+	ticker := time.NewTicker(h.ProbeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		conn, err := net.DialTimeout("tcp", addr, h.ProbeInterval)
+		if err != nil {
+			continue
+		}
+		conn.Close()
+
+		h.MarkSuccess(id)
+		return
+	}
+}
@@ -0,0 +1,79 @@
+package router
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHealthTrackerStartsHealthy(t *testing.T) {
+	h := NewHealthTracker(3, time.Hour)
+	if !h.IsHealthy("unknown") {
+		t.Fatal("a backend with no recorded history should be healthy")
+	}
+}
+
+func TestHealthTrackerTripsAfterThreshold(t *testing.T) {
+	h := NewHealthTracker(3, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		h.MarkFailure("a", "127.0.0.1:1")
+		if !h.IsHealthy("a") {
+			t.Fatalf("tripped after only %d failures, want 3", i+1)
+		}
+	}
+
+	h.MarkFailure("a", "127.0.0.1:1")
+	if h.IsHealthy("a") {
+		t.Fatal("expected the circuit to trip after 3 consecutive failures")
+	}
+}
+
+func TestHealthTrackerResetsFailureCountOnSuccess(t *testing.T) {
+	h := NewHealthTracker(3, time.Hour)
+
+	h.MarkFailure("a", "127.0.0.1:1")
+	h.MarkFailure("a", "127.0.0.1:1")
+	h.MarkSuccess("a")
+
+	// Only one more failure after the reset: shouldn't be enough to trip.
+	h.MarkFailure("a", "127.0.0.1:1")
+	if !h.IsHealthy("a") {
+		t.Fatal("MarkSuccess should have reset the consecutive failure count")
+	}
+}
+
+func TestHealthTrackerReadmitsAfterProbeSucceeds(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	h := NewHealthTracker(1, 10*time.Millisecond)
+	h.MarkFailure("a", ln.Addr().String())
+
+	if h.IsHealthy("a") {
+		t.Fatal("expected the circuit to trip on the first failure (threshold 1)")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if h.IsHealthy("a") {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatal("backend was never re-admitted after its probe should have succeeded")
+}
@@ -3,15 +3,25 @@ package router
 import (
 	"fmt"
 	"io"
-	"math/rand"
 	"net"
 	"net/http"
 	"router/config"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// defaultHealthFailureThreshold is the number of consecutive 5xx/
+	// timeout failures that trips a backend's passive health circuit.
+	defaultHealthFailureThreshold = 3
+
+	// defaultHealthProbeInterval is how often a tripped backend is probed
+	// for recovery.
+	defaultHealthProbeInterval = 10 * time.Second
+)
+
 const (
 	VcapBackendHeader = "X-Vcap-Backend"
 	VcapRouterHeader  = "X-Vcap-Router"
@@ -27,17 +37,83 @@ type Proxy struct {
 	*config.Config
 	*Registry
 	Varz
+
+	selector BackendSelector
+	stats    *BackendStats
+	health   *HealthTracker
+
+	trustedProxies []*net.IPNet
+	transport      *http.Transport
+
+	// HeaderRewriter, if set, is given a chance to add/remove request
+	// headers after the standard forwarding headers have been applied but
+	// before the request is sent upstream.
+	HeaderRewriter HeaderRewriter
+
+	// FlushInterval, if non-zero, is how often the response body is
+	// flushed to the client while it is being copied, mirroring
+	// httputil.ReverseProxy. Responses with a text/event-stream
+	// Content-Type are flushed on every write regardless of this setting.
+	FlushInterval time.Duration
+
+	// wg tracks in-flight calls to ServeHTTP (including the lifetime of a
+	// proxied WebSocket connection) so Shutdown can wait for them to drain.
+	// shutdownMu serializes admission (enter) against Shutdown so a request
+	// can never be admitted (wg.Add) after Shutdown has already started
+	// waiting on wg - see the comment on enter in shutdown.go.
+	shutdownMu sync.Mutex
+	wg         sync.WaitGroup
+	closing    atomic.Bool
+
+	// forceClose is closed when Shutdown's context expires before all
+	// in-flight work drained on its own; every per-request context derives
+	// from it so outstanding backend round trips and WebSocket splices get
+	// torn down instead of running forever.
+	forceClose chan struct{}
 }
 
 func NewProxy(c *config.Config, r *Registry, v Varz) *Proxy {
-	return &Proxy{
-		Config:   c,
-		Registry: r,
-		Varz:     v,
+	stats := NewBackendStats()
+
+	p := &Proxy{
+		Config:         c,
+		Registry:       r,
+		Varz:           v,
+		stats:          stats,
+		health:         NewHealthTracker(defaultHealthFailureThreshold, defaultHealthProbeInterval),
+		trustedProxies: parseTrustedProxies(c.TrustedProxies),
+		transport:      newTransport(c, r),
+		forceClose:     make(chan struct{}),
 	}
+	p.selector = newBackendSelector(c.LoadBalancingStrategy, stats)
+
+	return p
 }
 
+func newBackendSelector(strategy string, stats *BackendStats) BackendSelector {
+	switch strategy {
+	case "round-robin":
+		return NewRoundRobinSelector()
+	case "least-conn":
+		return NewLeastConnSelector(stats)
+	case "ewma":
+		return NewEWMALatencySelector(stats)
+	case "power-of-two":
+		return NewPowerOfTwoChoicesSelector(stats)
+	default:
+		return NewRandomSelector()
+	}
+}
+
+// Lookup finds a backend to serve req.
 func (p *Proxy) Lookup(req *http.Request) (Backend, bool) {
+	return p.LookupExcluding(req, nil)
+}
+
+// LookupExcluding finds a backend to serve req, never returning one whose
+// id is in exclude. Retry uses this to avoid re-trying a backend that just
+// failed.
+func (p *Proxy) LookupExcluding(req *http.Request, exclude map[string]bool) (Backend, bool) {
 	var b Backend
 	var ok bool
 
@@ -51,6 +127,10 @@ func (p *Proxy) Lookup(req *http.Request) (Backend, bool) {
 
 		// If there's only one backend, choose that
 		if len(x) == 1 {
+			if exclude[x[0]] {
+				return b, false
+			}
+
 			b, ok = p.Registry.LookupByBackendId(x[0])
 			if ok {
 				return b, true
@@ -59,23 +139,32 @@ func (p *Proxy) Lookup(req *http.Request) (Backend, bool) {
 			}
 		}
 
-		// Choose backend depending on sticky session
-		sticky, err := req.Cookie(VcapCookieId)
-		if err == nil {
-			y, ok := p.Registry.LookupByBackendIds(x)
-			if ok {
-				// Return backend if host and port match
-				for _, b := range y {
-					if sticky.Value == b.PrivateInstanceId {
-						return b, true
+		// Choose backend depending on sticky session. Skipped on a retry
+		// (exclude non-empty): re-sticking to the backend that just failed
+		// would defeat the point of retrying.
+		if len(exclude) == 0 {
+			sticky, err := req.Cookie(VcapCookieId)
+			if err == nil {
+				y, ok := p.Registry.LookupByBackendIds(x)
+				if ok {
+					// Return backend if host and port match
+					for _, b := range y {
+						if sticky.Value == b.PrivateInstanceId {
+							return b, true
+						}
 					}
-				}
 
-				// No matching backend found
+					// No matching backend found
+				}
 			}
 		}
 
-		b, ok = p.Registry.LookupByBackendId(x[rand.Intn(len(x))])
+		candidates := p.selectableCandidates(x, exclude)
+		if len(candidates) == 0 {
+			return b, false
+		}
+
+		b, ok = p.Registry.LookupByBackendId(p.selector.Select(candidates))
 		if ok {
 			return b, true
 		} else {
@@ -88,7 +177,58 @@ func (p *Proxy) Lookup(req *http.Request) (Backend, bool) {
 	return b, ok
 }
 
+// selectableCandidates narrows x down to the ids not in exclude, then
+// prefers the subset of those that are also passively healthy.
+func (p *Proxy) selectableCandidates(x []string, exclude map[string]bool) []string {
+	remaining := make([]string, 0, len(x))
+	for _, id := range x {
+		if !exclude[id] {
+			remaining = append(remaining, id)
+		}
+	}
+
+	if len(remaining) == 0 {
+		return nil
+	}
+
+	return p.healthyCandidates(remaining)
+}
+
+// healthyCandidates filters x down to the backend ids whose passive health
+// circuit is not tripped. If every candidate is unhealthy, x is returned
+// unfiltered so that Lookup can still make progress.
+func (p *Proxy) healthyCandidates(x []string) []string {
+	healthy := make([]string, 0, len(x))
+	for _, id := range x {
+		if p.health.IsHealthy(id) {
+			healthy = append(healthy, id)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return x
+	}
+
+	return healthy
+}
+
 func (p *Proxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !p.enter() {
+		p.WriteStatus(rw, http.StatusServiceUnavailable)
+		return
+	}
+	defer p.wg.Done()
+
+	if p.Config.MaxRequestBodyBytes > 0 && req.Body != nil {
+		req.Body = http.MaxBytesReader(rw, req.Body, p.Config.MaxRequestBodyBytes)
+	}
+
+	req, cancelOnForceClose := p.withForceClose(req)
+	defer cancelOnForceClose()
+
+	req, stopRequestTimeout := p.withRequestTimeout(req)
+	defer stopRequestTimeout()
+
 	if req.ProtoMajor != 1 && (req.ProtoMinor != 0 || req.ProtoMinor != 1) {
 		hj := rw.(http.Hijacker)
 
@@ -121,45 +261,64 @@ func (p *Proxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 
 	p.Registry.CaptureBackendRequest(x, start)
 	p.Varz.CaptureBackendRequest(x, req)
+	p.stats.StartRequest(x.PrivateInstanceId)
 
-	req.URL.Scheme = "http"
+	req.URL.Scheme = backendScheme(x)
 	req.URL.Host = x.CanonicalAddr()
 
-	// Add X-Forwarded-For
-	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
-		// We assume there is a trusted upstream (L7 LB) that properly
-		// strips client's XFF header
+	// Check if the connection is going to be upgraded to a WebSocket
+	// connection. This is long-lived by design, so RequestTimeout must not
+	// carry over into the lifetime of the splice.
+	if p.CheckWebSocket(rw, req) {
+		stopRequestTimeout()
+		p.ServeWebSocket(rw, req, x, start)
+		return
+	}
 
-		// This is sloppy but fine since we don't share this request or
-		// headers. Otherwise we should copy the underlying header and
-		// append
-		xff := append(req.Header["X-Forwarded-For"], host)
-		req.Header.Set("X-Forwarded-For", strings.Join(xff, ", "))
+	clientScheme := "http"
+	if req.TLS != nil {
+		clientScheme = "https"
 	}
+	setForwardedHeaders(req.Header, req.RemoteAddr, clientScheme, req.Host, p.trustedProxies)
+	removeHopByHopHeaders(req.Header)
 
-	// Check if the connection is going to be upgraded to a WebSocket connection
-	if p.CheckWebSocket(rw, req) {
-		p.ServeWebSocket(rw, req)
-		return
+	if p.HeaderRewriter != nil {
+		p.HeaderRewriter.Rewrite(req.Header)
 	}
 
 	// Use a new connection for every request
 	// Keep-alive can be bolted on later, if we want to
 	req.Close = true
-	req.Header.Del("Connection")
 
-	res, err := http.DefaultTransport.RoundTrip(req)
+	originalBackendId := x.PrivateInstanceId
+
+	result := p.forwardWithRetry(req, x)
+	x, res, err := result.backend, result.res, result.err
 
+	// EndRequest must match the StartRequest above, not whichever backend
+	// the retry loop ended on, or the originally-selected backend's active
+	// connection count never comes back down when a retry switches away
+	// from it.
 	latency := time.Since(start)
+	p.stats.EndRequest(originalBackendId, latency)
 
 	if err != nil {
 		p.Varz.CaptureBackendResponse(x, res, latency)
+		p.health.MarkFailure(x.PrivateInstanceId, x.CanonicalAddr())
 		p.WriteBadGateway(err, rw)
 		return
 	}
 
+	if res.StatusCode >= 500 {
+		p.health.MarkFailure(x.PrivateInstanceId, x.CanonicalAddr())
+	} else {
+		p.health.MarkSuccess(x.PrivateInstanceId)
+	}
+
 	p.Varz.CaptureBackendResponse(x, res, latency)
 
+	removeHopByHopHeaders(res.Header)
+
 	for k, vv := range res.Header {
 		for _, v := range vv {
 			rw.Header().Add(k, v)
@@ -191,58 +350,30 @@ func (p *Proxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	rw.WriteHeader(res.StatusCode)
 
 	if res.Body != nil {
-		var dst io.Writer = rw
-		io.Copy(dst, res.Body)
-	}
-}
-
-func (p *Proxy) CheckWebSocket(rw http.ResponseWriter, req *http.Request) bool {
-	return req.Header.Get("Connection") == "Upgrade" && req.Header.Get("Upgrade") == "websocket"
-}
-
-func (p *Proxy) ServeWebSocket(rw http.ResponseWriter, req *http.Request) {
-	var err error
-
-	hj := rw.(http.Hijacker)
-
-	dc, _, err := hj.Hijack()
-	if err != nil {
-		p.WriteBadGateway(err, rw)
-		return
-	}
-
-	defer dc.Close()
+		contentType := res.Header.Get("Content-Type")
+		if strings.HasPrefix(contentType, sseContentType) {
+			// SSE streams are long-lived by design; don't let RequestTimeout
+			// cut one off mid-stream.
+			stopRequestTimeout()
+		}
 
-	// Dial backend
-	uc, err := net.Dial("tcp", req.URL.Host)
-	if err != nil {
-		p.WriteBadGateway(err, rw)
-		return
-	}
+		dst := p.newFlushWriter(rw, contentType)
+		defer dst.Close()
 
-	defer uc.Close()
+		buf := copyBufPool.Get().(*[]byte)
+		defer copyBufPool.Put(buf)
 
-	// Write request
-	err = req.Write(uc)
-	if err != nil {
-		p.WriteBadGateway(err, rw)
-		return
+		io.CopyBuffer(dst, res.Body, *buf)
 	}
+}
 
-	errch := make(chan error, 2)
-
-	copy := func(dst io.Writer, src io.Reader) {
-		_, err := io.Copy(dst, src)
-		if err != nil {
-			errch <- err
-		}
+// backendScheme returns b's origin scheme, defaulting to "http" for
+// backends registered before scheme tracking existed.
+func backendScheme(b Backend) string {
+	if b.Scheme != "" {
+		return b.Scheme
 	}
-
-	go copy(uc, dc)
-	go copy(dc, uc)
-
-	// Don't care about error, both connections will be closed if necessary
-	<-errch
+	return "http"
 }
 
 func (p *Proxy) WriteStatus(rw http.ResponseWriter, code int) {
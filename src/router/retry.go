@@ -0,0 +1,212 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// isRetryableRequest reports whether req is eligible to be retried against
+// a different backend: either its method is inherently idempotent, or the
+// client opted in with an Idempotency-Key header.
+func isRetryableRequest(req *http.Request) bool {
+	return idempotentMethods[req.Method] || req.Header.Get("Idempotency-Key") != ""
+}
+
+// isRetryableStatus reports whether a backend's response status warrants
+// trying another backend instead of returning it to the client.
+func isRetryableStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable
+}
+
+// isRetryableError reports whether err looks like a connection-level
+// failure (reset, refused, timed out) as opposed to something retrying
+// another backend won't fix.
+func isRetryableError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// bufferRetryBody reads req's body into memory so it can be replayed
+// against a second backend, up to maxBytes. ok is false if the body is
+// larger than that, in which case the caller must not retry; req.Body is
+// then left readable from its first byte so the request can still be sent
+// once.
+func bufferRetryBody(req *http.Request, maxBytes int64) (getBody func() io.ReadCloser, ok bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return func() io.ReadCloser { return http.NoBody }, true
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(req.Body, maxBytes+1))
+	if err != nil {
+		req.Body.Close()
+		return nil, false
+	}
+	if int64(len(data)) > maxBytes {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), req.Body), req.Body}
+		return nil, false
+	}
+	req.Body.Close()
+
+	return func() io.ReadCloser { return ioutil.NopCloser(bytes.NewReader(data)) }, true
+}
+
+// retryBackoff returns a jittered exponential backoff for retry attempt n
+// (0-based): a random duration in [0, 50ms*2^n).
+func retryBackoff(n int) time.Duration {
+	max := 50 * time.Millisecond << uint(n)
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// forwardResult is the outcome of forwardWithRetry: the backend that
+// produced it, and its response or error.
+type forwardResult struct {
+	backend Backend
+	res     *http.Response
+	err     error
+}
+
+// forwardWithRetry sends req to backend via forwardOnce, retrying against
+// other backends (excluding any already tried) on a connection error or a
+// retryable 5xx, up to Config.MaxRetries times. Only idempotent requests
+// (or ones carrying Idempotency-Key) are retried; anything else is sent
+// exactly once. It returns the last attempt made.
+func (p *Proxy) forwardWithRetry(req *http.Request, backend Backend) forwardResult {
+	retryable := p.Config.MaxRetries > 0 && isRetryableRequest(req)
+
+	var getBody func() io.ReadCloser
+	if retryable {
+		var ok bool
+		getBody, ok = bufferRetryBody(req, p.Config.MaxRetryBodyBytes)
+		retryable = ok
+	}
+
+	tried := map[string]bool{backend.PrivateInstanceId: true}
+
+	for attempt := 0; ; attempt++ {
+		if getBody != nil {
+			req.Body = getBody()
+		}
+
+		req.URL.Host = backend.CanonicalAddr()
+		req.URL.Scheme = backendScheme(backend)
+
+		res, err := p.forwardOnce(req, backend)
+		result := forwardResult{backend: backend, res: res, err: err}
+
+		retryableFailure := false
+		if err != nil {
+			retryableFailure = isRetryableError(err)
+		} else if isRetryableStatus(res.StatusCode) {
+			retryableFailure = true
+		}
+
+		if !retryable || attempt >= p.Config.MaxRetries || !retryableFailure {
+			return result
+		}
+
+		next, ok := p.LookupExcluding(req, tried)
+		if !ok {
+			return result
+		}
+
+		// This attempt is being discarded in favor of next: trip its passive
+		// health circuit the same way ServeHTTP would for a final,
+		// non-retried failure, and close its body so its connection is
+		// released instead of leaking.
+		p.health.MarkFailure(backend.PrivateInstanceId, backend.CanonicalAddr())
+		if res != nil && res.Body != nil {
+			res.Body.Close()
+		}
+
+		time.Sleep(retryBackoff(attempt))
+
+		tried[next.PrivateInstanceId] = true
+		backend = next
+	}
+}
+
+// forwardOnce sends req to backend. If Config.HedgeAfter is set, req has no
+// body (hedging a request with a body would require reading it twice
+// concurrently, which isn't supported here), and req is idempotent (the
+// same test forwardWithRetry uses, since a cancelled loser may already have
+// executed its side effect on the backend), a second request is fired at
+// a different backend after that delay; whichever response arrives first
+// wins and the loser is cancelled via its request context. Cancelling the
+// loser doesn't guarantee it fails though - it may already have a complete
+// response in flight - so its result is drained and its body closed once
+// it eventually arrives, rather than left for the transport to never reclaim.
+func (p *Proxy) forwardOnce(req *http.Request, backend Backend) (*http.Response, error) {
+	if p.Config.HedgeAfter <= 0 || (req.Body != nil && req.Body != http.NoBody) || !isRetryableRequest(req) {
+		return p.transport.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+
+	type attempt struct {
+		res *http.Response
+		err error
+	}
+
+	results := make(chan attempt, 2)
+	inFlight := 1
+
+	go func() {
+		res, err := p.transport.RoundTrip(req.WithContext(ctx))
+		results <- attempt{res, err}
+	}()
+
+	timer := time.NewTimer(p.Config.HedgeAfter)
+	defer timer.Stop()
+
+	select {
+	case a := <-results:
+		cancel()
+		return a.res, a.err
+	case <-timer.C:
+	}
+
+	hedgeBackend, ok := p.LookupExcluding(req, map[string]bool{backend.PrivateInstanceId: true})
+	if ok {
+		inFlight++
+
+		hedgeReq := req.Clone(ctx)
+		hedgeReq.URL.Host = hedgeBackend.CanonicalAddr()
+		hedgeReq.URL.Scheme = backendScheme(hedgeBackend)
+
+		go func() {
+			res, err := p.transport.RoundTrip(hedgeReq)
+			results <- attempt{res, err}
+		}()
+	}
+
+	winner := <-results
+	cancel()
+
+	if inFlight > 1 {
+		go func() {
+			if loser := <-results; loser.res != nil {
+				loser.res.Body.Close()
+			}
+		}()
+	}
+
+	return winner.res, winner.err
+}
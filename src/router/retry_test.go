@@ -0,0 +1,107 @@
+package router
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsRetryableRequest(t *testing.T) {
+	get, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if !isRetryableRequest(get) {
+		t.Fatal("GET should be retryable")
+	}
+
+	post, _ := http.NewRequest(http.MethodPost, "http://example.com", nil)
+	if isRetryableRequest(post) {
+		t.Fatal("POST without Idempotency-Key should not be retryable")
+	}
+
+	post.Header.Set("Idempotency-Key", "abc123")
+	if !isRetryableRequest(post) {
+		t.Fatal("POST with Idempotency-Key should be retryable")
+	}
+}
+
+func TestIsRetryableStatus(t *testing.T) {
+	for _, code := range []int{http.StatusBadGateway, http.StatusServiceUnavailable} {
+		if !isRetryableStatus(code) {
+			t.Fatalf("status %d should be retryable", code)
+		}
+	}
+	for _, code := range []int{http.StatusOK, http.StatusNotFound, http.StatusInternalServerError} {
+		if isRetryableStatus(code) {
+			t.Fatalf("status %d should not be retryable", code)
+		}
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	if !isRetryableError(&net.OpError{Op: "dial", Err: errors.New("connection refused")}) {
+		t.Fatal("a *net.OpError should be retryable")
+	}
+	if isRetryableError(errors.New("some other failure")) {
+		t.Fatal("a plain error should not be retryable")
+	}
+}
+
+func TestBufferRetryBodyNilBody(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+
+	getBody, ok := bufferRetryBody(req, 1024)
+	if !ok {
+		t.Fatal("expected ok for a request with no body")
+	}
+	if getBody() != http.NoBody {
+		t.Fatal("expected http.NoBody for a request with no body")
+	}
+}
+
+func TestBufferRetryBodyWithinLimit(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com", strings.NewReader("hello"))
+
+	getBody, ok := bufferRetryBody(req, 1024)
+	if !ok {
+		t.Fatal("expected ok for a body within the limit")
+	}
+
+	body, err := ioutil.ReadAll(getBody())
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(body) != "hello" {
+		t.Fatalf("body = %q, want %q", body, "hello")
+	}
+
+	// getBody must be replayable for a second attempt.
+	body2, err := ioutil.ReadAll(getBody())
+	if err != nil {
+		t.Fatalf("ReadAll (second call): %v", err)
+	}
+	if string(body2) != "hello" {
+		t.Fatalf("second read body = %q, want %q", body2, "hello")
+	}
+}
+
+func TestBufferRetryBodyOverLimit(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com", strings.NewReader("this body is too long"))
+
+	if _, ok := bufferRetryBody(req, 4); ok {
+		t.Fatal("expected ok=false for a body over maxBytes")
+	}
+}
+
+func TestRetryBackoffStaysWithinBound(t *testing.T) {
+	for attempt := 0; attempt < 5; attempt++ {
+		max := 50 * time.Millisecond << uint(attempt)
+		for i := 0; i < 20; i++ {
+			if d := retryBackoff(attempt); d < 0 || d >= max {
+				t.Fatalf("attempt %d: retryBackoff returned %v, want [0, %v)", attempt, d, max)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Shutdown stops Proxy from accepting new requests (ServeHTTP replies 503
+// to anything that arrives after this is called) and waits for in-flight
+// HTTP and WebSocket handling to finish, mirroring http.Server.Shutdown. If
+// ctx expires first, every still-running request's context is cancelled via
+// forceClose - tearing down in-flight backend round trips and hijacked
+// WebSocket connections rather than leaving them to run forever - idle
+// pooled connections are closed too, and ctx.Err is returned.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.shutdownMu.Lock()
+	p.closing.Store(true)
+	p.shutdownMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		close(p.forceClose)
+		p.transport.CloseIdleConnections()
+		return ctx.Err()
+	}
+}
+
+// withForceClose derives req's context from p.forceClose so that if
+// Shutdown's deadline expires while this request is still being served,
+// its context is cancelled - unblocking any backend round trip and
+// signalling ServeWebSocket to tear down its hijacked connections. The
+// returned stop func must be called once the request is done to release
+// the small goroutine watching for that signal.
+func (p *Proxy) withForceClose(req *http.Request) (*http.Request, func()) {
+	ctx, cancel := context.WithCancel(req.Context())
+
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-p.forceClose:
+			cancel()
+		case <-stopped:
+		}
+	}()
+
+	stop := func() {
+		close(stopped)
+		cancel()
+	}
+
+	return req.WithContext(ctx), stop
+}
+
+// enter admits one request, returning false if Shutdown has already been
+// called. It holds shutdownMu across the closing check and the wg.Add so
+// that Shutdown can never observe wg at zero and return while a request is
+// still being admitted - the check-then-add has to be atomic with respect
+// to Shutdown flipping closing, or Shutdown could start waiting just
+// before a request that's about to increment wg.
+func (p *Proxy) enter() bool {
+	p.shutdownMu.Lock()
+	defer p.shutdownMu.Unlock()
+
+	if p.closing.Load() {
+		return false
+	}
+
+	p.wg.Add(1)
+	return true
+}
+
+// withRequestTimeout bounds req's context by Config.RequestTimeout, if set,
+// returning the possibly-rebound request and a stop func that disarms the
+// deadline. ServeHTTP calls stop for a WebSocket upgrade and for a
+// text/event-stream response, so neither a hijacked connection nor an SSE
+// body is cut off by RequestTimeout; any other response body, a chunked
+// download included, stays bounded by it while it is copied.
+func (p *Proxy) withRequestTimeout(req *http.Request) (*http.Request, func()) {
+	if p.Config.RequestTimeout <= 0 {
+		return req, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(p.Config.RequestTimeout, cancel)
+
+	stop := func() {
+		timer.Stop()
+	}
+
+	return req.WithContext(ctx), stop
+}
@@ -0,0 +1,60 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEnterRejectsAfterShutdown(t *testing.T) {
+	p := &Proxy{forceClose: make(chan struct{})}
+
+	if !p.enter() {
+		t.Fatal("enter should succeed before Shutdown is called")
+	}
+	p.wg.Done()
+
+	done := make(chan struct{})
+	go func() {
+		p.Shutdown(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown did not return once all in-flight work drained")
+	}
+
+	if p.enter() {
+		t.Fatal("enter should reject new requests once Shutdown has been called")
+	}
+}
+
+func TestShutdownWaitsForInFlightWork(t *testing.T) {
+	p := &Proxy{forceClose: make(chan struct{})}
+
+	if !p.enter() {
+		t.Fatal("enter should succeed before Shutdown is called")
+	}
+
+	shutdownReturned := make(chan struct{})
+	go func() {
+		p.Shutdown(context.Background())
+		close(shutdownReturned)
+	}()
+
+	select {
+	case <-shutdownReturned:
+		t.Fatal("Shutdown returned before the in-flight request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	p.wg.Done()
+
+	select {
+	case <-shutdownReturned:
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown did not return after the in-flight request finished")
+	}
+}
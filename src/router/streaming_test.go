@@ -0,0 +1,56 @@
+package router
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewFlushWriterForcesFlushForSSE(t *testing.T) {
+	p := &Proxy{}
+	rec := httptest.NewRecorder()
+
+	fw := p.newFlushWriter(rec, sseContentType)
+	defer fw.Close()
+
+	if !fw.forceFlush {
+		t.Fatal("expected forceFlush for an SSE content type")
+	}
+
+	if _, err := fw.Write([]byte("data: hi\n\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if rec.Flushed != true {
+		t.Fatal("expected Write to flush immediately for SSE")
+	}
+}
+
+func TestNewFlushWriterPlainResponseDoesNotForceFlush(t *testing.T) {
+	p := &Proxy{}
+	rec := httptest.NewRecorder()
+
+	fw := p.newFlushWriter(rec, "application/json")
+	defer fw.Close()
+
+	if fw.forceFlush {
+		t.Fatal("did not expect forceFlush for a plain JSON response")
+	}
+
+	if _, err := fw.Write([]byte("{}")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if rec.Flushed {
+		t.Fatal("did not expect Write to flush without forceFlush or a periodic interval")
+	}
+}
+
+func TestFlushWriterCloseIsIdempotent(t *testing.T) {
+	p := &Proxy{}
+	fw := p.newFlushWriter(httptest.NewRecorder(), "text/plain")
+
+	if err := fw.Close(); err != nil {
+		t.Fatalf("first Close: %v", err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package router
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"router/config"
+)
+
+// newTransport builds the http.Transport that Proxy uses for all backend
+// traffic - plain HTTP, HTTPS origins and WebSocket upgrades alike - so
+// connection pooling and TLS settings live in exactly one place instead of
+// relying on http.DefaultTransport.
+func newTransport(c *config.Config, registry *Registry) *http.Transport {
+	dialer := &net.Dialer{Timeout: c.DialTimeout}
+
+	// TLSHandshakeTimeout is deliberately not set here: per http.Transport's
+	// doc comment, it (and TLSClientConfig) are ignored once DialTLSContext
+	// is set. dialTLSContext below enforces c.TLSHandshakeTimeout itself.
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		DialTLSContext:        dialTLSContext(dialer, c, registry),
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConnsPerHost,
+	}
+}
+
+// dialTLSContext dials addr over TLS, using the SNI ServerName and client
+// certificate of whichever backend Registry has registered at that address
+// (for mTLS to the origin), and falling back to Config.TLSConfig otherwise.
+func dialTLSContext(dialer *net.Dialer, c *config.Config, registry *Registry) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		cfg := c.TLSConfig.Clone()
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+
+		if b, ok := registry.LookupByAddr(addr); ok {
+			if b.ServerName != "" {
+				cfg.ServerName = b.ServerName
+			}
+			if b.ClientCertificate != nil {
+				cfg.Certificates = []tls.Certificate{*b.ClientCertificate}
+			}
+		}
+
+		rawConn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		handshakeCtx := ctx
+		if c.TLSHandshakeTimeout > 0 {
+			var cancel context.CancelFunc
+			handshakeCtx, cancel = context.WithTimeout(ctx, c.TLSHandshakeTimeout)
+			defer cancel()
+		}
+
+		tlsConn := tls.Client(rawConn, cfg)
+		if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+
+		return tlsConn, nil
+	}
+}
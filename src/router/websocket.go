@@ -0,0 +1,153 @@
+package router
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// CheckWebSocket reports whether req is asking to switch protocols, i.e.
+// it carries an "Upgrade" token in its Connection header plus a non-empty
+// Upgrade header. This covers both websocket and other upgrade types such
+// as h2c, since the hijack-and-splice handling in ServeWebSocket doesn't
+// care which protocol is being switched to.
+func (p *Proxy) CheckWebSocket(rw http.ResponseWriter, req *http.Request) bool {
+	return httpguts.HeaderValuesContainsToken(req.Header["Connection"], "Upgrade") &&
+		req.Header.Get("Upgrade") != ""
+}
+
+// ServeWebSocket proxies a protocol-switching request end to end: it sends
+// the request through p.transport so TLS and proxy env vars behave the same
+// as for ordinary requests, and on a 101 response hijacks the client
+// connection and splices it with the io.ReadWriteCloser the transport hands
+// back for the now-upgraded backend connection. x and start are the backend
+// ServeHTTP already called stats.StartRequest with and the time it did so at;
+// ServeWebSocket owns ending that request and reporting its outcome to the
+// health tracker, since ServeHTTP returns as soon as this call does.
+func (p *Proxy) ServeWebSocket(rw http.ResponseWriter, req *http.Request, x Backend, start time.Time) {
+	failed := false
+	defer func() {
+		p.stats.EndRequest(x.PrivateInstanceId, time.Since(start))
+		if failed {
+			p.health.MarkFailure(x.PrivateInstanceId, x.CanonicalAddr())
+		} else {
+			p.health.MarkSuccess(x.PrivateInstanceId)
+		}
+	}()
+
+	res, err := p.transport.RoundTrip(req)
+	if err != nil {
+		failed = true
+		p.WriteBadGateway(err, rw)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		failed = res.StatusCode >= 500
+		relayResponse(rw, res)
+		return
+	}
+
+	backendConn, ok := res.Body.(io.ReadWriteCloser)
+	if !ok {
+		failed = true
+		p.WriteBadGateway(errors.New("websocket: backend transport did not return a duplex connection"), rw)
+		return
+	}
+	defer backendConn.Close()
+
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		failed = true
+		p.WriteBadGateway(errors.New("websocket: response writer does not support hijacking"), rw)
+		return
+	}
+
+	clientConn, clientBuf, err := hj.Hijack()
+	if err != nil {
+		failed = true
+		p.WriteBadGateway(err, rw)
+		return
+	}
+	defer clientConn.Close()
+
+	if err := write101Response(clientConn, res); err != nil {
+		failed = true
+		return
+	}
+
+	// The client may have pipelined its first frame in the same TCP
+	// segment as the original upgrade request; drain whatever bufio
+	// buffered alongside it before starting the bidirectional copy.
+	if n := clientBuf.Reader.Buffered(); n > 0 {
+		buffered := make([]byte, n)
+		if _, err := io.ReadFull(clientBuf.Reader, buffered); err == nil {
+			backendConn.Write(buffered)
+		}
+	}
+
+	errch := make(chan error, 2)
+
+	cp := func(dst io.Writer, src io.Reader) {
+		_, err := io.Copy(dst, src)
+		errch <- err
+	}
+
+	go cp(backendConn, clientConn)
+	go cp(clientConn, backendConn)
+
+	// req's context is cancelled either by the client disconnecting or by
+	// Proxy.Shutdown forcing a close; either way, the splice above has no
+	// other way to learn about it since it's just copying bytes between
+	// two net.Conns, so force both closed to unblock it.
+	watchdog := make(chan struct{})
+	defer close(watchdog)
+	go func() {
+		select {
+		case <-req.Context().Done():
+			clientConn.Close()
+			backendConn.Close()
+		case <-watchdog:
+		}
+	}()
+
+	// Don't care about error, both connections will be closed if necessary
+	<-errch
+}
+
+// write101Response writes a 101 Switching Protocols status line and the
+// backend's response headers (including any negotiated
+// Sec-WebSocket-Protocol/Extensions) back to the client connection. Header
+// names are written in canonical form.
+func write101Response(w io.Writer, res *http.Response) error {
+	if _, err := io.WriteString(w, "HTTP/1.1 101 Switching Protocols\r\n"); err != nil {
+		return err
+	}
+
+	if err := res.Header.Write(w); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
+
+// relayResponse is used when the backend declined the protocol switch; the
+// response it sent instead is forwarded to the client like any other
+// non-upgrade response.
+func relayResponse(rw http.ResponseWriter, res *http.Response) {
+	removeHopByHopHeaders(res.Header)
+
+	for k, vv := range res.Header {
+		for _, v := range vv {
+			rw.Header().Add(k, v)
+		}
+	}
+
+	rw.WriteHeader(res.StatusCode)
+	io.Copy(rw, res.Body)
+}
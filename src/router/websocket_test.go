@@ -0,0 +1,80 @@
+package router
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckWebSocketRequiresUpgradeTokenAndHeader(t *testing.T) {
+	p := &Proxy{}
+
+	upgrade := httptest.NewRequest(http.MethodGet, "/", nil)
+	upgrade.Header.Set("Connection", "keep-alive, Upgrade")
+	upgrade.Header.Set("Upgrade", "websocket")
+	if !p.CheckWebSocket(httptest.NewRecorder(), upgrade) {
+		t.Fatal("expected a request with Connection: Upgrade and an Upgrade header to be detected")
+	}
+
+	noUpgradeHeader := httptest.NewRequest(http.MethodGet, "/", nil)
+	noUpgradeHeader.Header.Set("Connection", "Upgrade")
+	if p.CheckWebSocket(httptest.NewRecorder(), noUpgradeHeader) {
+		t.Fatal("should not detect an upgrade without an Upgrade header")
+	}
+
+	noConnectionToken := httptest.NewRequest(http.MethodGet, "/", nil)
+	noConnectionToken.Header.Set("Upgrade", "websocket")
+	if p.CheckWebSocket(httptest.NewRecorder(), noConnectionToken) {
+		t.Fatal("should not detect an upgrade without an Upgrade token in Connection")
+	}
+}
+
+func TestWrite101ResponseWritesStatusLineAndHeaders(t *testing.T) {
+	res := &http.Response{Header: make(http.Header)}
+	res.Header.Set("Sec-WebSocket-Protocol", "chat")
+
+	var buf bytes.Buffer
+	if err := write101Response(&buf, res); err != nil {
+		t.Fatalf("write101Response: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.HasPrefix(out, "HTTP/1.1 101 Switching Protocols\r\n") {
+		t.Fatalf("missing status line, got %q", out)
+	}
+	if !strings.Contains(out, "Sec-Websocket-Protocol: chat\r\n") {
+		t.Fatalf("missing negotiated header, got %q", out)
+	}
+	if !strings.HasSuffix(out, "\r\n\r\n") {
+		t.Fatalf("missing trailing blank line, got %q", out)
+	}
+}
+
+func TestRelayResponseStripsHopByHopAndCopiesBody(t *testing.T) {
+	res := &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader("backend declined the upgrade")),
+	}
+	res.Header.Set("Connection", "close")
+	res.Header.Set("Content-Type", "text/plain")
+
+	rec := httptest.NewRecorder()
+	relayResponse(rec, res)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if got := rec.Header().Get("Connection"); got != "" {
+		t.Fatalf("Connection header should have been stripped, got %q", got)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/plain" {
+		t.Fatalf("Content-Type = %q, want it preserved", got)
+	}
+	if got := rec.Body.String(); got != "backend declined the upgrade" {
+		t.Fatalf("body = %q, want it copied through", got)
+	}
+}